@@ -20,7 +20,12 @@ import (
 )
 
 var (
-	retentionPeriod   = flagutil.NewDuration("retentionPeriod", 1, "Data with timestamps outside the retentionPeriod is automatically deleted")
+	retentionPeriod        = flagutil.NewDuration("retentionPeriod", 1, "Data with timestamps outside the retentionPeriod is automatically deleted")
+	maxDiskSpaceUsageBytes = flagutil.NewBytes("retention.maxDiskSpaceUsageBytes", 0, "The maximum disk space, which may be occupied by data and indexdb files "+
+		"at -storageDataPath. The oldest per-month partitions are automatically deleted when the total size of these files exceeds 90% of this limit, "+
+		"until the total size drops below 70% of this limit. Partitions for the current and future months are never deleted. "+
+		"Snapshots, caches and data, which isn't flushed to disk yet, aren't taken into account. "+
+		"Samples for the deleted partitions are rejected in the same way as samples outside -retentionPeriod, including after restart. The limit is disabled if set to 0")
 	snapshotAuthKey   = flag.String("snapshotAuthKey", "", "authKey, which must be passed in query string to /snapshot* pages")
 	forceMergeAuthKey = flag.String("forceMergeAuthKey", "", "authKey, which must be passed in query string to /internal/force_merge pages")
 	forceFlushAuthKey = flag.String("forceFlushAuthKey", "", "authKey, which must be passed in query string to /internal/force_flush pages")
@@ -74,6 +79,7 @@ func InitWithoutMetrics(resetCacheIfNeeded func(mrs []storage.MetricRow)) {
 	storage.SetFinalMergeDelay(*finalMergeDelay)
 	storage.SetBigMergeWorkersCount(*bigMergeConcurrency)
 	storage.SetSmallMergeWorkersCount(*smallMergeConcurrency)
+	storage.SetMaxDiskSpaceUsageBytes(int64(maxDiskSpaceUsageBytes.N))
 
 	logger.Infof("opening storage at %q with -retentionPeriod=%s", *DataPath, retentionPeriod)
 	startTime := time.Now()
@@ -402,6 +408,9 @@ func registerStorageMetrics() {
 	metrics.NewGauge(`vm_rows_deleted_total{type="storage/small"}`, func() float64 {
 		return float64(tm().SmallRowsDeleted)
 	})
+	metrics.NewGauge(`vm_partitions_dropped_by_disk_usage_total`, func() float64 {
+		return float64(tm().PartitionsDroppedByDiskUsage)
+	})
 
 	metrics.NewGauge(`vm_references{type="storage/big", name="parts"}`, func() float64 {
 		return float64(tm().BigPartsRefCount)
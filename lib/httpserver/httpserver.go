@@ -4,13 +4,16 @@ import (
 	"bufio"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/pprof"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
@@ -19,6 +22,7 @@ import (
 	"time"
 
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/flagutil"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/logger"
 	"github.com/VictoriaMetrics/VictoriaMetrics/lib/netutil"
 	"github.com/VictoriaMetrics/metrics"
@@ -31,6 +35,12 @@ var (
 	tlsCertFile = flag.String("tlsCertFile", "", "Path to file with TLS certificate. Used only if -tls is set. Prefer ECDSA certs instead of RSA certs, since RSA certs are slow")
 	tlsKeyFile  = flag.String("tlsKeyFile", "", "Path to file with TLS key. Used only if -tls is set")
 
+	mtlsEnable     = flag.Bool("mtls", false, "Whether to require valid client certificate for https requests. Requires -tls. See also -mtlsCAFile and -mtlsAllowedSAN")
+	mtlsCAFile     = flag.String("mtlsCAFile", "", "Optional path to TLS Root CA for verifying client certificates. Requires -mtls. The system CA is used by default")
+	mtlsAllowedSAN = flagutil.NewArray("mtlsAllowedSAN", "Optional regular expressions for Subject Alternative Names of client certificates. "+
+		"Client certificate must contain at least a single DNS name, email address, IP address or URI matching at least a single regular expression. "+
+		"Regular expressions are anchored to the beginning and the end of SAN. Requires -mtls. All the SANs are allowed by default")
+
 	pathPrefix = flag.String("http.pathPrefix", "", "An optional prefix to add to all the paths handled by http server. For example, if '-http.pathPrefix=/foo/bar' is set, "+
 		"then all the http requests will be handled on '/foo/bar/*' paths. This may be useful for proxied requests. "+
 		"See https://www.robustperception.io/using-external-urls-and-proxies-with-prometheus")
@@ -75,6 +85,9 @@ type RequestHandler func(w http.ResponseWriter, r *http.Request) bool
 //
 // The compression is also disabled if -http.disableResponseCompression flag is set.
 func Serve(addr string, rh RequestHandler) {
+	if err := checkMTLSFlags(); err != nil {
+		logger.Fatalf("%s", err)
+	}
 	scheme := "http"
 	if *tlsEnable {
 		scheme = "https"
@@ -95,11 +108,102 @@ func Serve(addr string, rh RequestHandler) {
 		cfg := &tls.Config{
 			Certificates: []tls.Certificate{cert},
 		}
+		if *mtlsEnable {
+			if err := initMTLSConfig(cfg, *mtlsCAFile, *mtlsAllowedSAN); err != nil {
+				logger.Fatalf("cannot initialize mTLS: %s", err)
+			}
+		}
 		ln = tls.NewListener(ln, cfg)
 	}
 	serveWithListener(addr, ln, rh)
 }
 
+// checkMTLSFlags returns an error if -mtls* flags are set without the flags they depend on.
+//
+// Otherwise the server would silently accept requests without client certificates.
+func checkMTLSFlags() error {
+	if *mtlsEnable && !*tlsEnable {
+		return fmt.Errorf("-mtls requires -tls")
+	}
+	if *mtlsCAFile != "" && !*mtlsEnable {
+		return fmt.Errorf("-mtlsCAFile requires -mtls")
+	}
+	if len(*mtlsAllowedSAN) > 0 && !*mtlsEnable {
+		return fmt.Errorf("-mtlsAllowedSAN requires -mtls")
+	}
+	return nil
+}
+
+// initMTLSConfig configures cfg for requiring and verifying client certificates.
+//
+// Client certificates are verified with the Root CA from caFile if it isn't empty. Otherwise the system CA is used.
+// Client certificates must contain at least a single SAN matching allowedSANs if allowedSANs isn't empty.
+func initMTLSConfig(cfg *tls.Config, caFile string, allowedSANs []string) error {
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	if caFile != "" {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("cannot read -mtlsCAFile=%q: %w", caFile, err)
+		}
+		cp := x509.NewCertPool()
+		if !cp.AppendCertsFromPEM(data) {
+			return fmt.Errorf("cannot parse PEM-encoded certificates from -mtlsCAFile=%q", caFile)
+		}
+		cfg.ClientCAs = cp
+	}
+	if len(allowedSANs) == 0 {
+		return nil
+	}
+	res, err := compileAllowedSANs(allowedSANs)
+	if err != nil {
+		return err
+	}
+	cfg.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+			return fmt.Errorf("missing verified client certificate")
+		}
+		cert := verifiedChains[0][0]
+		if !hasAllowedSAN(cert, res) {
+			return fmt.Errorf("client certificate with subject %q doesn't contain SAN matching -mtlsAllowedSAN=%q", cert.Subject, allowedSANs)
+		}
+		return nil
+	}
+	return nil
+}
+
+// compileAllowedSANs compiles exprs into regular expressions anchored to the beginning and the end of SAN.
+func compileAllowedSANs(exprs []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, expr := range exprs {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse -mtlsAllowedSAN=%q: %w", expr, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// hasAllowedSAN returns true if cert contains at least a single Subject Alternative Name matching res.
+func hasAllowedSAN(cert *x509.Certificate, res []*regexp.Regexp) bool {
+	sans := append([]string{}, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	for _, san := range sans {
+		for _, re := range res {
+			if re.MatchString(san) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func serveWithListener(addr string, ln net.Listener, rh RequestHandler) {
 	var s server
 	s.s = &http.Server{
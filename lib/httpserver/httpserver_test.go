@@ -0,0 +1,269 @@
+package httpserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCompileAllowedSANsFailure(t *testing.T) {
+	f := func(exprs []string) {
+		t.Helper()
+		res, err := compileAllowedSANs(exprs)
+		if err == nil {
+			t.Fatalf("expecting non-nil error for exprs=%q", exprs)
+		}
+		if res != nil {
+			t.Fatalf("expecting nil result for exprs=%q; got %v", exprs, res)
+		}
+	}
+	f([]string{"("})
+	f([]string{"foo", "[bar"})
+}
+
+func TestCheckMTLSFlags(t *testing.T) {
+	origTLSEnable, origMTLSEnable, origMTLSCAFile, origMTLSAllowedSAN := *tlsEnable, *mtlsEnable, *mtlsCAFile, *mtlsAllowedSAN
+	defer func() {
+		*tlsEnable, *mtlsEnable, *mtlsCAFile, *mtlsAllowedSAN = origTLSEnable, origMTLSEnable, origMTLSCAFile, origMTLSAllowedSAN
+	}()
+
+	f := func(tlsOn, mtlsOn bool, caFile string, allowedSANs []string, resultExpected bool) {
+		t.Helper()
+		*tlsEnable, *mtlsEnable, *mtlsCAFile, *mtlsAllowedSAN = tlsOn, mtlsOn, caFile, allowedSANs
+		err := checkMTLSFlags()
+		if (err == nil) != resultExpected {
+			t.Fatalf("unexpected result for tls=%v, mtls=%v, mtlsCAFile=%q, mtlsAllowedSAN=%q; err: %v", tlsOn, mtlsOn, caFile, allowedSANs, err)
+		}
+	}
+	f(false, false, "", nil, true)
+	f(true, false, "", nil, true)
+	f(true, true, "", nil, true)
+	f(true, true, "ca.pem", []string{"foo"}, true)
+
+	// mTLS flags without the flags they depend on.
+	f(false, true, "", nil, false)
+	f(false, true, "ca.pem", nil, false)
+	f(true, false, "ca.pem", nil, false)
+	f(true, false, "", []string{"foo"}, false)
+}
+
+func TestHasAllowedSAN(t *testing.T) {
+	f := func(cert *x509.Certificate, exprs []string, resultExpected bool) {
+		t.Helper()
+		res, err := compileAllowedSANs(exprs)
+		if err != nil {
+			t.Fatalf("unexpected error for exprs=%q: %s", exprs, err)
+		}
+		result := hasAllowedSAN(cert, res)
+		if result != resultExpected {
+			t.Fatalf("unexpected result for exprs=%q; got %v; want %v", exprs, result, resultExpected)
+		}
+	}
+	u, err := url.Parse("spiffe://cluster.local/ns/monitoring/sa/vmagent")
+	if err != nil {
+		t.Fatalf("cannot parse url: %s", err)
+	}
+	cert := &x509.Certificate{
+		DNSNames:       []string{"vmagent.monitoring.svc"},
+		EmailAddresses: []string{"ops@example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:           []*url.URL{u},
+	}
+	f(cert, nil, false)
+	f(cert, []string{"foo"}, false)
+
+	// Regular expressions must be anchored to the beginning and the end of SAN.
+	f(cert, []string{"vmagent"}, false)
+	f(cert, []string{"monitoring.svc"}, false)
+	f(cert, []string{"vmagent.monitoring"}, false)
+	f(cert, []string{"10.0.0.1|foo"}, true)
+	f(cert, []string{"foo|10.0.0"}, false)
+
+	f(cert, []string{`vmagent\..+`}, true)
+	f(cert, []string{"foo", ".+@example.com"}, true)
+	f(cert, []string{`10\.0\.0\.1`}, true)
+	f(cert, []string{"spiffe://cluster.local/ns/monitoring/.+"}, true)
+	f(&x509.Certificate{}, []string{".*"}, false)
+}
+
+func TestInitMTLSConfigFailure(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestInitMTLSConfigFailure")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+	garbageCAFile := tmpDir + "/garbage.pem"
+	if err := ioutil.WriteFile(garbageCAFile, []byte("foobar"), 0600); err != nil {
+		t.Fatalf("cannot write %q: %s", garbageCAFile, err)
+	}
+
+	f := func(caFile string, allowedSANs []string) {
+		t.Helper()
+		var cfg tls.Config
+		if err := initMTLSConfig(&cfg, caFile, allowedSANs); err == nil {
+			t.Fatalf("expecting non-nil error for caFile=%q, allowedSANs=%q", caFile, allowedSANs)
+		}
+	}
+	f(tmpDir+"/missing.pem", nil)
+	f(garbageCAFile, nil)
+	f("", []string{"("})
+}
+
+func TestMTLSHandshake(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "TestMTLSHandshake")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	caCert, caKey := newTestCert(t, nil, nil, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	})
+	caFile := tmpDir + "/ca.pem"
+	caData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})
+	if err := ioutil.WriteFile(caFile, caData, 0600); err != nil {
+		t.Fatalf("cannot write %q: %s", caFile, err)
+	}
+	caPool := x509.NewCertPool()
+	caPool.AddCert(caCert)
+
+	serverCert := newTestTLSCert(t, caCert, caKey, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	allowedClientCert := newTestTLSCert(t, caCert, caKey, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "allowed client"},
+		DNSNames:    []string{"vmagent.monitoring.svc"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	deniedClientCert := newTestTLSCert(t, caCert, caKey, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "denied client"},
+		DNSNames:    []string{"vmagent.other.svc"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	untrustedCACert, untrustedCAKey := newTestCert(t, nil, nil, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "untrusted CA"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	})
+	untrustedClientCert := newTestTLSCert(t, untrustedCACert, untrustedCAKey, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "untrusted client"},
+		DNSNames:    []string{"vmagent.monitoring.svc"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+	}
+	if err := initMTLSConfig(cfg, caFile, []string{`.+\.monitoring\.svc`}); err != nil {
+		t.Fatalf("cannot initialize mTLS config: %s", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot create listener: %s", err)
+	}
+	s := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}),
+	}
+	go func() {
+		_ = s.Serve(tls.NewListener(ln, cfg))
+	}()
+	defer func() {
+		_ = s.Close()
+	}()
+	serverURL := "https://" + ln.Addr().String() + "/"
+
+	f := func(clientCerts []tls.Certificate, resultExpected bool) {
+		t.Helper()
+		c := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs:      caPool,
+					Certificates: clientCerts,
+				},
+			},
+			Timeout: 5 * time.Second,
+		}
+		resp, err := c.Get(serverURL)
+		if err != nil {
+			if resultExpected {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			return
+		}
+		_ = resp.Body.Close()
+		if !resultExpected {
+			t.Fatalf("expecting non-nil error; got response with status code %d", resp.StatusCode)
+		}
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("unexpected status code; got %d; want %d", resp.StatusCode, http.StatusNoContent)
+		}
+	}
+	f([]tls.Certificate{allowedClientCert}, true)
+	f(nil, false)
+	f([]tls.Certificate{deniedClientCert}, false)
+	f([]tls.Certificate{untrustedClientCert}, false)
+}
+
+func newTestTLSCert(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, template *x509.Certificate) tls.Certificate {
+	t.Helper()
+	cert, key := newTestCert(t, parent, parentKey, template)
+	return tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  key,
+	}
+}
+
+// newTestCert creates a certificate from template signed by parent.
+//
+// The certificate is self-signed if parent is nil.
+func newTestCert(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, template *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %s", err)
+	}
+	serialNumber, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("cannot generate serial number: %s", err)
+	}
+	template.SerialNumber = serialNumber
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("cannot create certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("cannot parse certificate: %s", err)
+	}
+	return cert, key
+}
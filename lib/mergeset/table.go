@@ -346,6 +346,21 @@ func (tb *Table) UpdateMetrics(m *TableMetrics) {
 	m.IndexBlocksCacheMisses = atomic.LoadUint64(&historicalIndexBlockCacheMisses)
 }
 
+// FileSizeBytes returns the size of file-based parts in tb.
+//
+// In-memory parts aren't counted, since they don't occupy disk space yet.
+func (tb *Table) FileSizeBytes() uint64 {
+	n := uint64(0)
+	tb.partsLock.Lock()
+	for _, pw := range tb.parts {
+		if pw.mp == nil {
+			n += pw.p.size
+		}
+	}
+	tb.partsLock.Unlock()
+	return n
+}
+
 // AddItems adds the given items to the tb.
 func (tb *Table) AddItems(items [][]byte) error {
 	var err error
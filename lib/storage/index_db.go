@@ -268,6 +268,15 @@ func (db *indexDB) UpdateMetrics(m *IndexDBMetrics) {
 	})
 }
 
+// fileSizeBytes returns the size of file-based parts in db and in its extDB.
+func (db *indexDB) fileSizeBytes() uint64 {
+	n := db.tb.FileSizeBytes()
+	db.doExtDB(func(extDB *indexDB) {
+		n += extDB.tb.FileSizeBytes()
+	})
+	return n
+}
+
 func (db *indexDB) doExtDB(f func(extDB *indexDB)) bool {
 	db.extDBLock.Lock()
 	extDB := db.extDB
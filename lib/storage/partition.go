@@ -348,6 +348,24 @@ type partitionMetrics struct {
 	BigMergeNeedFreeDiskSpace   uint64
 }
 
+// fileSizeBytes returns the size of file-based parts in pt.
+//
+// In-memory parts aren't counted, since they don't occupy disk space yet.
+func (pt *partition) fileSizeBytes() uint64 {
+	n := uint64(0)
+	pt.partsLock.Lock()
+	for _, pw := range pt.bigParts {
+		n += pw.p.size
+	}
+	for _, pw := range pt.smallParts {
+		if pw.mp == nil {
+			n += pw.p.size
+		}
+	}
+	pt.partsLock.Unlock()
+	return n
+}
+
 // UpdateMetrics updates m with metrics from pt.
 func (pt *partition) UpdateMetrics(m *partitionMetrics) {
 	rawRowsLen := uint64(pt.rawRows.Len())
@@ -101,6 +101,7 @@ type Storage struct {
 	currHourMetricIDsUpdaterWG sync.WaitGroup
 	nextDayMetricIDsUpdaterWG  sync.WaitGroup
 	retentionWatcherWG         sync.WaitGroup
+	diskUsageWatcherWG         sync.WaitGroup
 
 	// The snapshotLock prevents from concurrent creation of snapshots,
 	// since this may result in snapshots without recently added data,
@@ -187,6 +188,7 @@ func OpenStorage(path string, retentionMsecs int64) (*Storage, error) {
 	s.startCurrHourMetricIDsUpdater()
 	s.startNextDayMetricIDsUpdater()
 	s.startRetentionWatcher()
+	s.startDiskUsageWatcher()
 
 	return s, nil
 }
@@ -484,6 +486,27 @@ func (s *Storage) retentionWatcher() {
 	}
 }
 
+func (s *Storage) startDiskUsageWatcher() {
+	s.diskUsageWatcherWG.Add(1)
+	go func() {
+		s.diskUsageWatcher()
+		s.diskUsageWatcherWG.Done()
+	}()
+}
+
+func (s *Storage) diskUsageWatcher() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.tb.dropPartitionsExceedingDiskUsage(s.idb().fileSizeBytes())
+		}
+	}
+}
+
 func (s *Storage) startCurrHourMetricIDsUpdater() {
 	s.currHourMetricIDsUpdaterWG.Add(1)
 	go func() {
@@ -572,6 +595,7 @@ func (s *Storage) MustClose() {
 	close(s.stop)
 
 	s.retentionWatcherWG.Wait()
+	s.diskUsageWatcherWG.Wait()
 	s.currHourMetricIDsUpdaterWG.Wait()
 	s.nextDayMetricIDsUpdaterWG.Wait()
 
@@ -1240,7 +1264,7 @@ func (s *Storage) add(rows []rawRow, mrs []MetricRow, precisionBits uint8) ([]ra
 			if firstWarn == nil {
 				metricName := getUserReadableMetricName(mr.MetricNameRaw)
 				firstWarn = fmt.Errorf("cannot insert row with too small timestamp %d outside the retention; minimum allowed timestamp is %d; "+
-					"probably you need updating -retentionPeriod command-line flag; metricName: %s",
+					"probably you need updating -retentionPeriod or -retention.maxDiskSpaceUsageBytes command-line flags; metricName: %s",
 					mr.Timestamp, minTimestamp, metricName)
 			}
 			atomic.AddUint64(&s.tooSmallTimestampRows, 1)
@@ -2,8 +2,11 @@ package storage
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -17,6 +20,18 @@ import (
 
 // table represents a single table with time series data.
 type table struct {
+	// Atomic counters must be at the top of struct for proper 8-byte alignment on 32-bit archs.
+	// See https://github.com/VictoriaMetrics/VictoriaMetrics/issues/212
+
+	partitionsDroppedByDiskUsage uint64
+
+	// minTimestampForDiskUsage is the minimum timestamp for rows, which may be added to the table
+	// while -retention.maxDiskSpaceUsageBytes is set.
+	// It is raised when partitions are dropped by dropPartitionsExceedingDiskUsage,
+	// so the dropped partitions aren't re-created by rows with timestamps inside the retention.
+	// It is persisted to minTimestampForDiskUsageFilename, so it survives restarts.
+	minTimestampForDiskUsage int64
+
 	path                string
 	smallPartitionsPath string
 	bigPartitionsPath   string
@@ -114,6 +129,11 @@ func openTable(path string, getDeletedMetricIDs func() *uint64set.Set, retention
 		return nil, fmt.Errorf("cannot create %q: %w", bigSnapshotsPath, err)
 	}
 
+	minTimestampForDiskUsage, err := loadMinTimestampForDiskUsage(path)
+	if err != nil {
+		return nil, err
+	}
+
 	// Open partitions.
 	pts, err := openPartitions(smallPartitionsPath, bigPartitionsPath, getDeletedMetricIDs, retentionMsecs)
 	if err != nil {
@@ -121,6 +141,8 @@ func openTable(path string, getDeletedMetricIDs func() *uint64set.Set, retention
 	}
 
 	tb := &table{
+		minTimestampForDiskUsage: minTimestampForDiskUsage,
+
 		path:                path,
 		smallPartitionsPath: smallPartitionsPath,
 		bigPartitionsPath:   bigPartitionsPath,
@@ -227,6 +249,8 @@ type TableMetrics struct {
 	partitionMetrics
 
 	PartitionsRefCount uint64
+
+	PartitionsDroppedByDiskUsage uint64
 }
 
 // UpdateMetrics updates m with metrics from tb.
@@ -237,6 +261,8 @@ func (tb *table) UpdateMetrics(m *TableMetrics) {
 		m.PartitionsRefCount += atomic.LoadUint64(&ptw.refCount)
 	}
 	tb.ptwsLock.Unlock()
+
+	m.PartitionsDroppedByDiskUsage += atomic.LoadUint64(&tb.partitionsDroppedByDiskUsage)
 }
 
 // ForceMergePartitions force-merges partitions in tb with names starting from the given partitionNamePrefix.
@@ -329,8 +355,10 @@ func (tb *table) AddRows(rows []rawRow) error {
 	// The slowest path - there are rows that don't fit any existing partition.
 	// Create new partitions for these rows.
 	// Do this under tb.ptwsLock.
-	minTimestamp, maxTimestamp := tb.getMinMaxTimestamps()
 	tb.ptwsLock.Lock()
+	// Obtain min and max timestamps under tb.ptwsLock, since dropPartitionsExceedingDiskUsage
+	// may raise the min timestamp concurrently.
+	minTimestamp, maxTimestamp := tb.getMinMaxTimestamps()
 	var errors []error
 	for i := range missingRows {
 		r := &missingRows[i]
@@ -378,6 +406,10 @@ func (tb *table) getMinMaxTimestamps() (int64, int64) {
 		// Negative timestamps aren't supported by the storage.
 		minTimestamp = 0
 	}
+	if n := atomic.LoadInt64(&tb.minTimestampForDiskUsage); maxDiskSpaceUsageBytes > 0 && minTimestamp < n {
+		// Skip rows for partitions dropped because of -retention.maxDiskSpaceUsageBytes.
+		minTimestamp = n
+	}
 	if maxTimestamp < 0 {
 		maxTimestamp = (1 << 63) - 1
 	}
@@ -416,19 +448,158 @@ func (tb *table) retentionWatcher() {
 		tb.ptws = dst
 		tb.ptwsLock.Unlock()
 
-		if len(ptwsDrop) == 0 {
-			continue
+		mustDropPartitions(ptwsDrop)
+	}
+}
+
+// diskUsageHighWatermark is the share of -retention.maxDiskSpaceUsageBytes,
+// which triggers dropping the oldest partitions.
+const diskUsageHighWatermark = 0.9
+
+// diskUsageLowWatermark is the share of -retention.maxDiskSpaceUsageBytes,
+// which must be reached after dropping the oldest partitions.
+//
+// The gap between diskUsageHighWatermark and diskUsageLowWatermark prevents from frequent drops
+// when the disk usage fluctuates around the limit.
+const diskUsageLowWatermark = 0.7
+
+// dropPartitionsExceedingDiskUsage drops the oldest partitions in tb if the size of their files
+// plus extraBytes exceeds diskUsageHighWatermark of the limit set via SetMaxDiskSpaceUsageBytes.
+//
+// extraBytes must contain the size of other files in the storage such as indexdb.
+//
+// Partitions are dropped until the total size becomes smaller than diskUsageLowWatermark of the limit.
+// Partitions containing the current time or future time are never dropped, since they receive the ingested data.
+//
+// Rows for the dropped partitions are skipped afterwards in the same way as rows outside the retention.
+func (tb *table) dropPartitionsExceedingDiskUsage(extraBytes uint64) {
+	maxBytes := maxDiskSpaceUsageBytes
+	if maxBytes <= 0 {
+		return
+	}
+	highBytes := uint64(float64(maxBytes) * diskUsageHighWatermark)
+	lowBytes := uint64(float64(maxBytes) * diskUsageLowWatermark)
+	now := int64(fasttime.UnixTimestamp() * 1000)
+
+	tb.ptwsLock.Lock()
+	var candidates []*partitionWrapper
+	sizes := make(map[*partitionWrapper]uint64, len(tb.ptws))
+	totalBytes := extraBytes
+	for _, ptw := range tb.ptws {
+		n := ptw.pt.fileSizeBytes()
+		sizes[ptw] = n
+		totalBytes += n
+		if ptw.pt.tr.MaxTimestamp < now {
+			candidates = append(candidates, ptw)
 		}
+	}
+	if totalBytes <= highBytes {
+		tb.ptwsLock.Unlock()
+		return
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].pt.tr.MinTimestamp < candidates[j].pt.tr.MinTimestamp
+	})
+	var ptwsDrop []*partitionWrapper
+	minTimestamp := atomic.LoadInt64(&tb.minTimestampForDiskUsage)
+	for len(ptwsDrop) < len(candidates) && totalBytes > lowBytes {
+		ptw := candidates[len(ptwsDrop)]
+		ptwsDrop = append(ptwsDrop, ptw)
+		logger.Warnf("dropping partition %q with size %d bytes, since the total size of storage files at %q is %d bytes, "+
+			"which exceeds %d bytes (%.0f%% of -retention.maxDiskSpaceUsageBytes=%d)",
+			ptw.pt.name, sizes[ptw], tb.path, totalBytes, lowBytes, diskUsageLowWatermark*100, maxBytes)
+		totalBytes -= sizes[ptw]
+		if n := ptw.pt.tr.MaxTimestamp + 1; n > minTimestamp {
+			minTimestamp = n
+		}
+	}
+	if len(ptwsDrop) > 0 {
+		// Persist the new min timestamp before dropping the partitions,
+		// so rows for the dropped partitions are skipped after unclean shutdown.
+		mustSaveMinTimestampForDiskUsage(tb.path, minTimestamp)
+		atomic.StoreInt64(&tb.minTimestampForDiskUsage, minTimestamp)
+	}
+	dst := tb.ptws[:0]
+	for _, ptw := range tb.ptws {
+		if !containsPartitionWrapper(ptwsDrop, ptw) {
+			dst = append(dst, ptw)
+		}
+	}
+	tb.ptws = dst
+	tb.ptwsLock.Unlock()
 
-		// There are paritions to drop. Drop them.
+	if totalBytes > uint64(maxBytes) {
+		logger.Warnf("the total size of storage files at %q is %d bytes after dropping old partitions, which exceeds -retention.maxDiskSpaceUsageBytes=%d; "+
+			"partitions for the current and future months cannot be dropped; increase -retention.maxDiskSpaceUsageBytes or free up disk space",
+			tb.path, totalBytes, maxBytes)
+	}
+	atomic.AddUint64(&tb.partitionsDroppedByDiskUsage, uint64(len(ptwsDrop)))
+	mustDropPartitions(ptwsDrop)
+}
+
+const minTimestampForDiskUsageFilename = "min_timestamp_for_disk_usage"
 
-		// Remove table references from partitions, so they will be eventually
-		// closed and dropped after all the pending searches are done.
-		for _, ptw := range ptwsDrop {
-			ptw.scheduleToDrop()
-			ptw.decRef()
+func loadMinTimestampForDiskUsage(tablePath string) (int64, error) {
+	path := tablePath + "/" + minTimestampForDiskUsageFilename
+	if !fs.IsPathExist(path) {
+		return 0, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read %q: %w", path, err)
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse min timestamp from %q: %w", path, err)
+	}
+	return n, nil
+}
+
+func mustSaveMinTimestampForDiskUsage(tablePath string, minTimestamp int64) {
+	path := tablePath + "/" + minTimestampForDiskUsageFilename
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, []byte(strconv.FormatInt(minTimestamp, 10)), 0644); err != nil {
+		logger.Panicf("FATAL: cannot write %q: %s", tmpPath, err)
+	}
+	// Atomically replace the previous file, so it is never lost on unclean shutdown.
+	if err := os.Rename(tmpPath, path); err != nil {
+		logger.Panicf("FATAL: cannot move %q to %q: %s", tmpPath, path, err)
+	}
+	fs.MustSyncPath(tablePath)
+}
+
+func containsPartitionWrapper(ptws []*partitionWrapper, ptw *partitionWrapper) bool {
+	for _, x := range ptws {
+		if x == ptw {
+			return true
 		}
 	}
+	return false
+}
+
+func mustDropPartitions(ptws []*partitionWrapper) {
+	// Remove table references from partitions, so they will be eventually
+	// closed and dropped after all the pending searches are done.
+	for _, ptw := range ptws {
+		ptw.scheduleToDrop()
+		ptw.decRef()
+	}
+}
+
+var maxDiskSpaceUsageBytes int64
+
+// SetMaxDiskSpaceUsageBytes sets the maximum disk space usage for data and indexdb files in the storage.
+//
+// The oldest partitions are dropped when the total size of these files approaches maxBytes.
+// The limit is disabled if maxBytes <= 0.
+//
+// Rows for the dropped partitions are skipped while the limit is enabled, including after restarts,
+// since the minimum allowed timestamp is persisted in the table directory.
+// Such rows are accepted again if the limit is disabled.
+//
+// This function may be called only before Storage initialization.
+func SetMaxDiskSpaceUsageBytes(maxBytes int64) {
+	maxDiskSpaceUsageBytes = maxBytes
 }
 
 // GetPartitions appends tb's partitions snapshot to dst and returns the result.
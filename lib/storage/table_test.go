@@ -2,7 +2,12 @@ package storage
 
 import (
 	"os"
+	"reflect"
+	"sort"
 	"testing"
+
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fasttime"
+	"github.com/VictoriaMetrics/VictoriaMetrics/lib/fs"
 )
 
 func TestTableOpenClose(t *testing.T) {
@@ -57,3 +62,180 @@ func TestTableOpenMultipleTimes(t *testing.T) {
 		}
 	}
 }
+
+func TestTableDropPartitionsExceedingDiskUsage(t *testing.T) {
+	const path = "TestTableDropPartitionsExceedingDiskUsage"
+
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+
+	tb, err := openTable(path, nilGetDeletedMetricIDs, maxRetentionMsecs)
+	if err != nil {
+		t.Fatalf("cannot open table: %s", err)
+	}
+	defer func() {
+		tb.MustClose()
+	}()
+
+	// Create partitions for 3 previous months and for the current month with the same number of rows.
+	const partitionsCount = 4
+	var r rawRow
+	r.PrecisionBits = 24
+	now := int64(fasttime.UnixTimestamp() * 1000)
+	firstTimestamp := now - (partitionsCount-1)*msecsPerMonth
+	timestamp := firstTimestamp
+	for i := 0; i < partitionsCount; i++ {
+		var rows []rawRow
+		for j := 0; j < 1000; j++ {
+			r.TSID.MetricID = uint64(j)
+			r.Timestamp = timestamp - int64(j)
+			r.Value = float64(j)
+			rows = append(rows, r)
+		}
+		if err := tb.AddRows(rows); err != nil {
+			t.Fatalf("cannot add rows to table: %s", err)
+		}
+		timestamp += msecsPerMonth
+	}
+
+	// Flush the added rows to disk, since in-memory parts aren't taken into account.
+	tb.flushRawRows()
+	ptws := tb.GetPartitions(nil)
+	for _, ptw := range ptws {
+		if _, err := ptw.pt.flushInmemoryParts(nil, true); err != nil {
+			t.Fatalf("cannot flush inmemory parts: %s", err)
+		}
+	}
+	tb.PutPartitions(ptws)
+
+	// Create a partition for a future month. It may be created by rows with timestamps
+	// in the near future at the end of the current month.
+	pt, err := createPartition(now+msecsPerMonth, tb.smallPartitionsPath, tb.bigPartitionsPath, tb.getDeletedMetricIDs, tb.retentionMsecs)
+	if err != nil {
+		t.Fatalf("cannot create partition: %s", err)
+	}
+	tb.ptwsLock.Lock()
+	tb.addPartitionNolock(pt)
+	tb.ptwsLock.Unlock()
+
+	// getPartitions returns partition names and sizes sorted by partition time.
+	getPartitions := func() ([]string, []uint64) {
+		ptws := tb.GetPartitions(nil)
+		defer tb.PutPartitions(ptws)
+		sort.Slice(ptws, func(i, j int) bool {
+			return ptws[i].pt.tr.MinTimestamp < ptws[j].pt.tr.MinTimestamp
+		})
+		var names []string
+		var sizes []uint64
+		for _, ptw := range ptws {
+			names = append(names, ptw.pt.name)
+			sizes = append(sizes, ptw.pt.fileSizeBytes())
+		}
+		return names, sizes
+	}
+	checkPartitions := func(namesExpected []string) {
+		t.Helper()
+		names, _ := getPartitions()
+		if !reflect.DeepEqual(names, namesExpected) {
+			t.Fatalf("unexpected partitions; got %q; want %q", names, namesExpected)
+		}
+	}
+	checkDroppedPartitionDirs := func(names []string) {
+		t.Helper()
+		for _, name := range names {
+			for _, dir := range []string{tb.smallPartitionsPath + "/" + name, tb.bigPartitionsPath + "/" + name} {
+				if fs.IsPathExist(dir) {
+					t.Fatalf("the directory %q for the dropped partition must be removed", dir)
+				}
+			}
+		}
+	}
+	checkDroppedCount := func(nExpected uint64) {
+		t.Helper()
+		var m TableMetrics
+		tb.UpdateMetrics(&m)
+		if m.PartitionsDroppedByDiskUsage != nExpected {
+			t.Fatalf("unexpected PartitionsDroppedByDiskUsage; got %d; want %d", m.PartitionsDroppedByDiskUsage, nExpected)
+		}
+	}
+
+	names, sizes := getPartitions()
+	if len(names) != partitionsCount+1 {
+		t.Fatalf("unexpected number of partitions; got %d; want %d; partitions: %q", len(names), partitionsCount+1, names)
+	}
+	totalBytes := uint64(0)
+	for i, n := range sizes[:partitionsCount] {
+		if n == 0 {
+			t.Fatalf("partition %q mustn't be empty on disk", names[i])
+		}
+		totalBytes += n
+	}
+
+	// The limit is disabled by default.
+	tb.dropPartitionsExceedingDiskUsage(0)
+	checkPartitions(names)
+	checkDroppedCount(0)
+
+	defer SetMaxDiskSpaceUsageBytes(0)
+
+	// Partitions mustn't be dropped if their size is below the high watermark.
+	SetMaxDiskSpaceUsageBytes(int64(totalBytes * 2))
+	tb.dropPartitionsExceedingDiskUsage(0)
+	checkPartitions(names)
+	checkDroppedCount(0)
+
+	// Partitions must be dropped until their size drops below the low watermark,
+	// even if dropping the oldest partition is enough for fitting the limit.
+	remainingBytes := sizes[2] + sizes[3]
+	maxBytes := uint64(float64(remainingBytes) / diskUsageLowWatermark * 1.2)
+	if float64(totalBytes) <= float64(maxBytes)*diskUsageHighWatermark {
+		t.Fatalf("total size %d must exceed the high watermark for maxBytes=%d", totalBytes, maxBytes)
+	}
+	if totalBytes-sizes[0] > maxBytes {
+		t.Fatalf("dropping the oldest partition must be enough for fitting maxBytes=%d; sizes: %d", maxBytes, sizes)
+	}
+	if float64(totalBytes-sizes[0]) <= float64(maxBytes)*diskUsageLowWatermark {
+		t.Fatalf("dropping the oldest partition mustn't be enough for reaching the low watermark for maxBytes=%d; sizes: %d", maxBytes, sizes)
+	}
+	SetMaxDiskSpaceUsageBytes(int64(maxBytes))
+	tb.dropPartitionsExceedingDiskUsage(0)
+	checkPartitions(names[2:])
+	checkDroppedPartitionDirs(names[:2])
+	checkDroppedCount(2)
+
+	// Rows for the dropped partitions must be skipped instead of re-creating the partitions.
+	r.Timestamp = firstTimestamp
+	if err := tb.AddRows([]rawRow{r}); err != nil {
+		t.Fatalf("cannot add row for the dropped partition: %s", err)
+	}
+	checkPartitions(names[2:])
+
+	// The size of other files such as indexdb must be taken into account.
+	SetMaxDiskSpaceUsageBytes(int64(remainingBytes * 2))
+	tb.dropPartitionsExceedingDiskUsage(0)
+	checkPartitions(names[2:])
+	tb.dropPartitionsExceedingDiskUsage(remainingBytes * 2)
+	checkPartitions(names[3:])
+	checkDroppedPartitionDirs(names[:3])
+	checkDroppedCount(3)
+
+	// Too small limit mustn't drop partitions for the current and future months.
+	SetMaxDiskSpaceUsageBytes(1)
+	tb.dropPartitionsExceedingDiskUsage(0)
+	checkPartitions(names[3:])
+	checkDroppedCount(3)
+
+	// Rows for the dropped partitions must be skipped after the table is re-opened.
+	tb.MustClose()
+	tb, err = openTable(path, nilGetDeletedMetricIDs, maxRetentionMsecs)
+	if err != nil {
+		t.Fatalf("cannot re-open table: %s", err)
+	}
+	r.Timestamp = firstTimestamp + 2*msecsPerMonth
+	if err := tb.AddRows([]rawRow{r}); err != nil {
+		t.Fatalf("cannot add row for the dropped partition: %s", err)
+	}
+	checkPartitions(names[3:])
+	checkDroppedPartitionDirs(names[:3])
+}